@@ -0,0 +1,103 @@
+// Command factoriod-exporter exposes Prometheus metrics for one or more
+// Factorio servers, scraped over RCON.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gopkg.in/yaml.v3"
+
+	"github.com/glorfischi/factoriod/pkg/factorio/exporter"
+	"github.com/glorfischi/factoriod/pkg/rcon"
+)
+
+// config is the YAML schema read from the path given on the command line.
+type config struct {
+	ListenAddress string         `yaml:"listen_address"`
+	Servers       []serverConfig `yaml:"servers"`
+}
+
+type serverConfig struct {
+	Name     string `yaml:"name"`
+	Address  string `yaml:"address"`
+	Password string `yaml:"password"`
+	// Timeout bounds every RCON command issued while scraping this server.
+	// Metrics are still only collected when Prometheus itself scrapes
+	// /metrics; this doesn't schedule scrapes on its own, so there is
+	// deliberately no separate scrape-interval field here — the collector
+	// is pull-based and Prometheus's own scrape_interval already governs
+	// how often a server is scraped.
+	Timeout duration `yaml:"timeout"`
+}
+
+// duration wraps time.Duration so it can be written in the config as a
+// string like "10s" instead of a raw number of nanoseconds.
+type duration time.Duration
+
+func (d *duration) UnmarshalYAML(value *yaml.Node) error {
+	parsed, err := time.ParseDuration(value.Value)
+	if err != nil {
+		return fmt.Errorf("parsing duration %q: %w", value.Value, err)
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+func main() {
+	configPath := flag.String("config", "factoriod-exporter.yaml", "path to the exporter's YAML config file")
+	flag.Parse()
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("factoriod-exporter: %v", err)
+	}
+
+	servers := make([]exporter.Server, 0, len(cfg.Servers))
+	for _, s := range cfg.Servers {
+		c, err := rcon.Dial(s.Address, rcon.WithPassword(s.Password), rcon.WithAutoReconnect(true))
+		if err != nil {
+			log.Fatalf("factoriod-exporter: dial %s (%s): %v", s.Name, s.Address, err)
+		}
+		servers = append(servers, exporter.Server{
+			Name:    s.Name,
+			Client:  c,
+			Timeout: time.Duration(s.Timeout),
+		})
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(exporter.NewCollector(servers...))
+
+	listenAddress := cfg.ListenAddress
+	if listenAddress == "" {
+		listenAddress = ":9837"
+	}
+
+	http.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	log.Printf("factoriod-exporter: serving /metrics on %s for %d server(s)", listenAddress, len(servers))
+	log.Fatal(http.ListenAndServe(listenAddress, nil))
+}
+
+func loadConfig(path string) (config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return config{}, fmt.Errorf("opening config: %w", err)
+	}
+	defer f.Close()
+
+	var cfg config
+	if err := yaml.NewDecoder(f).Decode(&cfg); err != nil {
+		return config{}, fmt.Errorf("parsing config: %w", err)
+	}
+	if len(cfg.Servers) == 0 {
+		return config{}, fmt.Errorf("no servers configured")
+	}
+	return cfg, nil
+}
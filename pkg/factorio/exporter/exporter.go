@@ -0,0 +1,219 @@
+// Package exporter turns one or more Factorio servers into a
+// prometheus.Collector, scraping them over RCON.
+package exporter
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/glorfischi/factoriod/pkg/factorio"
+	"github.com/glorfischi/factoriod/pkg/rcon"
+)
+
+const namespace = "factorio"
+
+var (
+	playersOnlineDesc = prometheus.NewDesc(
+		namespace+"_players_online",
+		"Number of players currently connected to the server.",
+		[]string{"server"}, nil,
+	)
+	evolutionDesc = prometheus.NewDesc(
+		namespace+"_evolution",
+		"Enemy force evolution factor, broken down by contributing cause.",
+		[]string{"server", "factor"}, nil,
+	)
+	gameTickDesc = prometheus.NewDesc(
+		namespace+"_game_tick",
+		"Current game tick.",
+		[]string{"server"}, nil,
+	)
+	pollutionTotalDesc = prometheus.NewDesc(
+		namespace+"_pollution_total",
+		"Total pollution currently present across all surfaces.",
+		[]string{"server"}, nil,
+	)
+	itemProductionDesc = prometheus.NewDesc(
+		namespace+"_force_item_production_total",
+		"Cumulative count of an item produced by a force.",
+		[]string{"server", "force", "item"}, nil,
+	)
+	itemConsumptionDesc = prometheus.NewDesc(
+		namespace+"_force_item_consumption_total",
+		"Cumulative count of an item consumed by a force.",
+		[]string{"server", "force", "item"}, nil,
+	)
+)
+
+// Server is one Factorio server to scrape metrics from.
+type Server struct {
+	// Name labels every metric scraped from Client, and should be unique
+	// across the Servers passed to NewCollector.
+	Name   string
+	Client *rcon.Client
+	// Timeout bounds every RCON command issued while scraping Client. Zero
+	// means no timeout.
+	Timeout time.Duration
+}
+
+// Collector is a prometheus.Collector that scrapes one or more Factorio
+// servers over RCON on every Collect call, plus tracks the latency and
+// failure count of the RCON commands it issues while doing so.
+type Collector struct {
+	servers []scrapeTarget
+
+	cmdDuration *prometheus.HistogramVec
+	cmdFailures *prometheus.CounterVec
+}
+
+type scrapeTarget struct {
+	name    string
+	client  *factorio.Client
+	timeout time.Duration
+}
+
+// NewCollector returns a Collector scraping every given Server.
+func NewCollector(servers ...Server) *Collector {
+	targets := make([]scrapeTarget, 0, len(servers))
+	for _, s := range servers {
+		targets = append(targets, scrapeTarget{
+			name:    s.Name,
+			client:  factorio.New(s.Client),
+			timeout: s.Timeout,
+		})
+	}
+
+	return &Collector{
+		servers: targets,
+		cmdDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "rcon",
+			Name:      "command_duration_seconds",
+			Help:      "Latency of RCON commands issued while scraping a Factorio server.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"server"}),
+		cmdFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "rcon",
+			Name:      "command_failures_total",
+			Help:      "Number of RCON commands that failed while scraping a Factorio server.",
+		}, []string{"server"}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- playersOnlineDesc
+	ch <- evolutionDesc
+	ch <- gameTickDesc
+	ch <- pollutionTotalDesc
+	ch <- itemProductionDesc
+	ch <- itemConsumptionDesc
+	c.cmdDuration.Describe(ch)
+	c.cmdFailures.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, t := range c.servers {
+		t.collect(ch, c.cmdDuration, c.cmdFailures)
+	}
+	c.cmdDuration.Collect(ch)
+	c.cmdFailures.Collect(ch)
+}
+
+// record times fn, observing its latency and, on error, incrementing the
+// failure counter, both labelled with t.name. fn is called with a context
+// bounded by t.timeout, if any.
+func (t scrapeTarget) record(duration *prometheus.HistogramVec, failures *prometheus.CounterVec, fn func(context.Context) error) error {
+	ctx := context.Background()
+	if t.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	err := fn(ctx)
+	duration.WithLabelValues(t.name).Observe(time.Since(start).Seconds())
+	if err != nil {
+		failures.WithLabelValues(t.name).Inc()
+	}
+	return err
+}
+
+func (t scrapeTarget) collect(ch chan<- prometheus.Metric, duration *prometheus.HistogramVec, failures *prometheus.CounterVec) {
+	var players []factorio.Player
+	err := t.record(duration, failures, func(ctx context.Context) error {
+		p, err := t.client.Players(ctx)
+		players = p
+		return err
+	})
+	if err == nil {
+		ch <- prometheus.MustNewConstMetric(playersOnlineDesc, prometheus.GaugeValue, float64(len(players)), t.name)
+	}
+
+	var evo factorio.Evolution
+	err = t.record(duration, failures, func(ctx context.Context) error {
+		e, err := t.client.Evolution(ctx)
+		evo = e
+		return err
+	})
+	if err == nil {
+		ch <- prometheus.MustNewConstMetric(evolutionDesc, prometheus.GaugeValue, evo.Factor, t.name, "factor")
+		ch <- prometheus.MustNewConstMetric(evolutionDesc, prometheus.GaugeValue, evo.Time, t.name, "time")
+		ch <- prometheus.MustNewConstMetric(evolutionDesc, prometheus.GaugeValue, evo.Pollution, t.name, "pollution")
+		ch <- prometheus.MustNewConstMetric(evolutionDesc, prometheus.GaugeValue, evo.SpawnerKills, t.name, "spawner_kills")
+	}
+
+	var tp tickAndPollution
+	err = t.record(duration, failures, func(ctx context.Context) error {
+		return t.client.LuaJSON(ctx, tickAndPollutionSnippet, &tp)
+	})
+	if err == nil {
+		ch <- prometheus.MustNewConstMetric(gameTickDesc, prometheus.GaugeValue, tp.Tick, t.name)
+		ch <- prometheus.MustNewConstMetric(pollutionTotalDesc, prometheus.GaugeValue, tp.Pollution, t.name)
+	}
+
+	var flows map[string]itemFlow
+	err = t.record(duration, failures, func(ctx context.Context) error {
+		return t.client.LuaJSON(ctx, forceItemFlowSnippet, &flows)
+	})
+	if err == nil {
+		for force, flow := range flows {
+			for item, count := range flow.Input {
+				ch <- prometheus.MustNewConstMetric(itemProductionDesc, prometheus.CounterValue, count, t.name, force, item)
+			}
+			for item, count := range flow.Output {
+				ch <- prometheus.MustNewConstMetric(itemConsumptionDesc, prometheus.CounterValue, count, t.name, force, item)
+			}
+		}
+	}
+}
+
+type tickAndPollution struct {
+	Tick      float64 `json:"tick"`
+	Pollution float64 `json:"pollution"`
+}
+
+const tickAndPollutionSnippet = `
+	local pollution = 0
+	for _, surface in pairs(game.surfaces) do
+		pollution = pollution + surface.get_total_pollution()
+	end
+	return {tick = game.tick, pollution = pollution}`
+
+type itemFlow struct {
+	Input  map[string]float64 `json:"input"`
+	Output map[string]float64 `json:"output"`
+}
+
+const forceItemFlowSnippet = `
+	local result = {}
+	for name, force in pairs(game.forces) do
+		local stats = force.item_production_statistics
+		result[name] = {input = stats.input_counts, output = stats.output_counts}
+	end
+	return result`
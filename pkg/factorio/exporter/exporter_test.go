@@ -0,0 +1,118 @@
+package exporter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/glorfischi/factoriod/pkg/rcon"
+	"github.com/glorfischi/factoriod/pkg/rcon/rcontest"
+)
+
+func newTestServer(t *testing.T) (*rcon.Client, func()) {
+	t.Helper()
+	s := rcontest.NewServer(func(cmd string) string {
+		switch {
+		case cmd == "/players online":
+			return "Players (1):\n  alice (online)"
+		case strings.Contains(cmd, "evolution_factor"):
+			return `{"factor": 0.5, "time": 0.1, "pollution": 0.2, "spawner_kills": 0.2}`
+		case strings.Contains(cmd, "get_total_pollution"):
+			return `{"tick": 123, "pollution": 42}`
+		case strings.Contains(cmd, "item_production_statistics"):
+			return `{"player": {"input": {"iron-plate": 10}, "output": {"copper-plate": 20}}}`
+		default:
+			t.Errorf("unexpected command %q", cmd)
+			return ""
+		}
+	}, "secret")
+	rc, err := rcon.Dial(s.Addr(), rcon.WithPassword("secret"))
+	if err != nil {
+		s.Close()
+		t.Fatalf("rcon.Dial: %v", err)
+	}
+	return rc, func() {
+		rc.Close()
+		s.Close()
+	}
+}
+
+func collect(t *testing.T, c *Collector) map[string][]*dto.Metric {
+	t.Helper()
+	ch := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	got := make(map[string][]*dto.Metric)
+	go func() {
+		defer close(done)
+		for m := range ch {
+			var dm dto.Metric
+			if err := m.Write(&dm); err != nil {
+				t.Errorf("writing metric: %v", err)
+				continue
+			}
+			name := m.Desc().String()
+			got[name] = append(got[name], &dm)
+		}
+	}()
+	c.Collect(ch)
+	close(ch)
+	<-done
+	return got
+}
+
+func TestCollect(t *testing.T) {
+	rc, closeAll := newTestServer(t)
+	defer closeAll()
+
+	c := NewCollector(Server{Name: "test", Client: rc})
+	got := collect(t, c)
+
+	var sawPlayers, sawTick bool
+	for name, metrics := range got {
+		switch {
+		case strings.Contains(name, "players_online"):
+			sawPlayers = true
+			if got, want := metrics[0].GetGauge().GetValue(), 1.0; got != want {
+				t.Errorf("players_online = %v, want %v", got, want)
+			}
+		case strings.Contains(name, "game_tick"):
+			sawTick = true
+			if got, want := metrics[0].GetGauge().GetValue(), 123.0; got != want {
+				t.Errorf("game_tick = %v, want %v", got, want)
+			}
+		}
+	}
+	if !sawPlayers {
+		t.Error("collect: missing players_online metric")
+	}
+	if !sawTick {
+		t.Error("collect: missing game_tick metric")
+	}
+}
+
+func TestCollectRecordsFailures(t *testing.T) {
+	rc, closeAll := newTestServer(t)
+	rc.Close()
+	defer closeAll()
+
+	c := NewCollector(Server{Name: "test", Client: rc})
+	collect(t, c)
+
+	ch := make(chan prometheus.Metric, 16)
+	c.cmdFailures.Collect(ch)
+	close(ch)
+
+	var total float64
+	for m := range ch {
+		var dm dto.Metric
+		if err := m.Write(&dm); err != nil {
+			t.Fatalf("writing metric: %v", err)
+		}
+		total += dm.GetCounter().GetValue()
+	}
+	if total == 0 {
+		t.Error("cmdFailures: expected at least one recorded failure against a closed client")
+	}
+}
@@ -0,0 +1,206 @@
+// Package factorio provides typed helpers for the Factorio server console,
+// layered on top of pkg/rcon so callers don't have to build raw command
+// strings or parse free-form console output themselves.
+package factorio
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/glorfischi/factoriod/pkg/rcon"
+)
+
+// Client wraps an rcon.Client with typed helpers for the commands a
+// Factorio server console exposes.
+type Client struct {
+	rcon *rcon.Client
+}
+
+// New returns a Client that runs its commands over c.
+func New(c *rcon.Client) *Client {
+	return &Client{rcon: c}
+}
+
+// Player is one entry of the server's online player list.
+type Player struct {
+	Name string
+}
+
+// Players returns the players currently online, parsed from
+// "/players online".
+func (c *Client) Players(ctx context.Context) ([]Player, error) {
+	out, err := c.rcon.CommandContext(ctx, "/players online")
+	if err != nil {
+		return nil, fmt.Errorf("factorio: players: %w", err)
+	}
+
+	var players []Player
+	for _, name := range parseNameList(out) {
+		players = append(players, Player{Name: strings.TrimSuffix(name, " (online)")})
+	}
+	return players, nil
+}
+
+// Admins returns the server's admin list, parsed from "/admins".
+func (c *Client) Admins(ctx context.Context) ([]string, error) {
+	out, err := c.rcon.CommandContext(ctx, "/admins")
+	if err != nil {
+		return nil, fmt.Errorf("factorio: admins: %w", err)
+	}
+	return parseNameList(out), nil
+}
+
+// parseNameList parses the common shape of Factorio's list commands: a
+// header line such as "Players (2):" followed by one name per line.
+func parseNameList(out string) []string {
+	lines := strings.Split(out, "\n")
+	var names []string
+	for _, line := range lines[1:] {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		names = append(names, line)
+	}
+	return names
+}
+
+// Seed returns the map generation seed, parsed from "/seed".
+func (c *Client) Seed(ctx context.Context) (uint32, error) {
+	out, err := c.rcon.CommandContext(ctx, "/seed")
+	if err != nil {
+		return 0, fmt.Errorf("factorio: seed: %w", err)
+	}
+	seed, err := strconv.ParseUint(strings.TrimSpace(out), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("factorio: seed: parsing %q: %w", out, err)
+	}
+	return uint32(seed), nil
+}
+
+// Evolution is the enemy force's evolution factor and its breakdown by
+// contributing cause.
+type Evolution struct {
+	Factor       float64 `json:"factor"`
+	Time         float64 `json:"time"`
+	Pollution    float64 `json:"pollution"`
+	SpawnerKills float64 `json:"spawner_kills"`
+}
+
+// Evolution returns the enemy force's evolution factor.
+func (c *Client) Evolution(ctx context.Context) (Evolution, error) {
+	const snippet = `
+		local f = game.forces["enemy"]
+		return {
+			factor = f.evolution_factor,
+			time = f.evolution_factor_by_time,
+			pollution = f.evolution_factor_by_pollution,
+			spawner_kills = f.evolution_factor_by_killing_spawners,
+		}`
+
+	var e Evolution
+	if err := c.LuaJSON(ctx, snippet, &e); err != nil {
+		return Evolution{}, fmt.Errorf("factorio: evolution: %w", err)
+	}
+	return e, nil
+}
+
+// Ban bans player from the server. reason may be empty.
+func (c *Client) Ban(ctx context.Context, player, reason string) error {
+	if _, err := c.rcon.CommandContext(ctx, withArg("/ban", player, reason)); err != nil {
+		return fmt.Errorf("factorio: ban %s: %w", player, err)
+	}
+	return nil
+}
+
+// Unban lifts a ban on player.
+func (c *Client) Unban(ctx context.Context, player string) error {
+	if _, err := c.rcon.CommandContext(ctx, fmt.Sprintf("/unban %s", player)); err != nil {
+		return fmt.Errorf("factorio: unban %s: %w", player, err)
+	}
+	return nil
+}
+
+// Kick disconnects player from the server. reason may be empty.
+func (c *Client) Kick(ctx context.Context, player, reason string) error {
+	if _, err := c.rcon.CommandContext(ctx, withArg("/kick", player, reason)); err != nil {
+		return fmt.Errorf("factorio: kick %s: %w", player, err)
+	}
+	return nil
+}
+
+// Promote grants player admin rights.
+func (c *Client) Promote(ctx context.Context, player string) error {
+	if _, err := c.rcon.CommandContext(ctx, fmt.Sprintf("/promote %s", player)); err != nil {
+		return fmt.Errorf("factorio: promote %s: %w", player, err)
+	}
+	return nil
+}
+
+// Demote revokes player's admin rights.
+func (c *Client) Demote(ctx context.Context, player string) error {
+	if _, err := c.rcon.CommandContext(ctx, fmt.Sprintf("/demote %s", player)); err != nil {
+		return fmt.Errorf("factorio: demote %s: %w", player, err)
+	}
+	return nil
+}
+
+// Mute silences player's in-game chat.
+func (c *Client) Mute(ctx context.Context, player string) error {
+	if _, err := c.rcon.CommandContext(ctx, fmt.Sprintf("/mute %s", player)); err != nil {
+		return fmt.Errorf("factorio: mute %s: %w", player, err)
+	}
+	return nil
+}
+
+// SaveGame triggers a server-side save. name may be empty to use the
+// server's default save name.
+func (c *Client) SaveGame(ctx context.Context, name string) error {
+	cmd := "/server-save"
+	if name != "" {
+		cmd = fmt.Sprintf("/server-save %s", name)
+	}
+	if _, err := c.rcon.CommandContext(ctx, cmd); err != nil {
+		return fmt.Errorf("factorio: save game: %w", err)
+	}
+	return nil
+}
+
+// Lua runs snippet as the body of an anonymous function on the server and
+// returns whatever it prints via rcon.print, saving callers from having to
+// wrap and escape the command themselves.
+func (c *Client) Lua(ctx context.Context, snippet string) (string, error) {
+	cmd := fmt.Sprintf("/silent-command rcon.print((function() %s end)())", snippet)
+	out, err := c.rcon.CommandContext(ctx, cmd)
+	if err != nil {
+		return "", fmt.Errorf("factorio: lua: %w", err)
+	}
+	return out, nil
+}
+
+// LuaJSON runs snippet the same way as Lua, but additionally encodes its
+// return value with game.table_to_json and unmarshals the result into out,
+// which must be a pointer.
+func (c *Client) LuaJSON(ctx context.Context, snippet string, out interface{}) error {
+	cmd := fmt.Sprintf("/silent-command rcon.print(game.table_to_json((function() %s end)()))", snippet)
+	raw, err := c.rcon.CommandContext(ctx, cmd)
+	if err != nil {
+		return fmt.Errorf("factorio: lua json: %w", err)
+	}
+	if err := json.Unmarshal([]byte(raw), out); err != nil {
+		return fmt.Errorf("factorio: lua json: unmarshal %q: %w", raw, err)
+	}
+	return nil
+}
+
+// withArg appends player and, if non-empty, arg to cmd as space-separated
+// command arguments.
+func withArg(cmd, player, arg string) string {
+	if arg == "" {
+		return fmt.Sprintf("%s %s", cmd, player)
+	}
+	return fmt.Sprintf("%s %s %s", cmd, player, arg)
+}
@@ -0,0 +1,178 @@
+package factorio
+
+import (
+	"context"
+	"testing"
+
+	"github.com/glorfischi/factoriod/pkg/rcon"
+	"github.com/glorfischi/factoriod/pkg/rcon/rcontest"
+)
+
+func newClient(t *testing.T, handler func(cmd string) string) (*Client, func()) {
+	t.Helper()
+	s := rcontest.NewServer(handler, "secret")
+	rc, err := rcon.Dial(s.Addr(), rcon.WithPassword("secret"))
+	if err != nil {
+		s.Close()
+		t.Fatalf("rcon.Dial: %v", err)
+	}
+	return New(rc), func() {
+		rc.Close()
+		s.Close()
+	}
+}
+
+func TestPlayers(t *testing.T) {
+	c, closeAll := newClient(t, func(cmd string) string {
+		if cmd != "/players online" {
+			t.Errorf("unexpected command %q", cmd)
+		}
+		return "Players (2):\n  alice (online)\n  bob (online)"
+	})
+	defer closeAll()
+
+	players, err := c.Players(context.Background())
+	if err != nil {
+		t.Fatalf("Players: %v", err)
+	}
+	want := []Player{{Name: "alice"}, {Name: "bob"}}
+	if len(players) != len(want) {
+		t.Fatalf("Players = %+v, want %+v", players, want)
+	}
+	for i := range want {
+		if players[i] != want[i] {
+			t.Fatalf("Players[%d] = %+v, want %+v", i, players[i], want[i])
+		}
+	}
+}
+
+func TestAdmins(t *testing.T) {
+	c, closeAll := newClient(t, func(cmd string) string {
+		if cmd != "/admins" {
+			t.Errorf("unexpected command %q", cmd)
+		}
+		return "Admins (1):\n  alice"
+	})
+	defer closeAll()
+
+	admins, err := c.Admins(context.Background())
+	if err != nil {
+		t.Fatalf("Admins: %v", err)
+	}
+	if want := []string{"alice"}; len(admins) != 1 || admins[0] != want[0] {
+		t.Fatalf("Admins = %v, want %v", admins, want)
+	}
+}
+
+func TestSeed(t *testing.T) {
+	c, closeAll := newClient(t, func(cmd string) string {
+		if cmd != "/seed" {
+			t.Errorf("unexpected command %q", cmd)
+		}
+		return "1234567"
+	})
+	defer closeAll()
+
+	seed, err := c.Seed(context.Background())
+	if err != nil {
+		t.Fatalf("Seed: %v", err)
+	}
+	if seed != 1234567 {
+		t.Fatalf("Seed = %d, want 1234567", seed)
+	}
+}
+
+func TestEvolution(t *testing.T) {
+	c, closeAll := newClient(t, func(cmd string) string {
+		return `{"factor":0.5,"time":0.1,"pollution":0.2,"spawner_kills":0.2}`
+	})
+	defer closeAll()
+
+	e, err := c.Evolution(context.Background())
+	if err != nil {
+		t.Fatalf("Evolution: %v", err)
+	}
+	want := Evolution{Factor: 0.5, Time: 0.1, Pollution: 0.2, SpawnerKills: 0.2}
+	if e != want {
+		t.Fatalf("Evolution = %+v, want %+v", e, want)
+	}
+}
+
+func TestModerationCommands(t *testing.T) {
+	var got string
+	c, closeAll := newClient(t, func(cmd string) string {
+		got = cmd
+		return ""
+	})
+	defer closeAll()
+
+	ctx := context.Background()
+
+	cases := []struct {
+		do   func() error
+		want string
+	}{
+		{func() error { return c.Ban(ctx, "alice", "") }, "/ban alice"},
+		{func() error { return c.Ban(ctx, "alice", "griefing") }, "/ban alice griefing"},
+		{func() error { return c.Unban(ctx, "alice") }, "/unban alice"},
+		{func() error { return c.Kick(ctx, "alice", "") }, "/kick alice"},
+		{func() error { return c.Kick(ctx, "alice", "afk") }, "/kick alice afk"},
+		{func() error { return c.Promote(ctx, "alice") }, "/promote alice"},
+		{func() error { return c.Demote(ctx, "alice") }, "/demote alice"},
+		{func() error { return c.Mute(ctx, "alice") }, "/mute alice"},
+		{func() error { return c.SaveGame(ctx, "") }, "/server-save"},
+		{func() error { return c.SaveGame(ctx, "backup") }, "/server-save backup"},
+	}
+	for _, tc := range cases {
+		if err := tc.do(); err != nil {
+			t.Errorf("%s: %v", tc.want, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("command = %q, want %q", got, tc.want)
+		}
+	}
+}
+
+func TestLua(t *testing.T) {
+	var got string
+	c, closeAll := newClient(t, func(cmd string) string {
+		got = cmd
+		return "42"
+	})
+	defer closeAll()
+
+	out, err := c.Lua(context.Background(), "return 42")
+	if err != nil {
+		t.Fatalf("Lua: %v", err)
+	}
+	if out != "42" {
+		t.Fatalf("Lua = %q, want %q", out, "42")
+	}
+	if want := "/silent-command rcon.print((function() return 42 end)())"; got != want {
+		t.Fatalf("command = %q, want %q", got, want)
+	}
+}
+
+func TestLuaJSON(t *testing.T) {
+	var got string
+	c, closeAll := newClient(t, func(cmd string) string {
+		got = cmd
+		return `{"a":1,"b":"two"}`
+	})
+	defer closeAll()
+
+	var out struct {
+		A int    `json:"a"`
+		B string `json:"b"`
+	}
+	if err := c.LuaJSON(context.Background(), "return {a=1,b='two'}", &out); err != nil {
+		t.Fatalf("LuaJSON: %v", err)
+	}
+	if out.A != 1 || out.B != "two" {
+		t.Fatalf("LuaJSON out = %+v", out)
+	}
+	if want := "/silent-command rcon.print(game.table_to_json((function() return {a=1,b='two'} end)()))"; got != want {
+		t.Fatalf("command = %q, want %q", got, want)
+	}
+}
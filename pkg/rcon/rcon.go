@@ -1,12 +1,19 @@
+// Package rcon implements a client for the Source RCON protocol used by
+// Factorio's in-game server console.
 package rcon
 
 import (
 	"bytes"
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type packetType uint32
@@ -17,6 +24,24 @@ const authResponseType packetType = 2
 const execType = 2
 const responseType = 0
 
+// sentinelBit is OR'd into a request id to mark the terminator packet sent
+// right after an exec packet. Servers reply to an unrecognised request type
+// with an empty/"Unknown request" response carrying the same id, which lets
+// the reader reliably detect the end of a (possibly multi-packet) response
+// without guessing based on size or timing.
+const sentinelBit int32 = 1 << 30
+
+// keepAliveCmd is the no-op command issued by WithKeepAlive to detect a
+// dead connection on an otherwise idle Client.
+const keepAliveCmd = "/time"
+
+const initialReconnectBackoff = 500 * time.Millisecond
+const maxReconnectBackoff = 30 * time.Second
+
+// ErrClosed is returned by in-flight and future commands once the Client has
+// been closed.
+var ErrClosed = errors.New("rcon: client closed")
+
 type packet struct {
 	// The packet id field is a 32-bit little endian integer chosen by the client for each request.
 	id int32
@@ -62,19 +87,80 @@ func (p *packet) UnmarshalBinary(data []byte) error {
 	}
 	p.id = int32(binary.LittleEndian.Uint32(data[0:]))
 	p.kind = packetType(int32(binary.LittleEndian.Uint32(data[4:])))
-	p.body = string(data[8:])
+	// The body is followed by its own NUL terminator and then an empty,
+	// NUL-terminated string reserved by the spec, so two trailing zero
+	// bytes are always part of the wire body and not the payload.
+	p.body = strings.TrimRight(string(data[8:]), "\x00")
 	return nil
 }
 
+// pendingCmd tracks an in-flight CommandContext call. body accumulates
+// response fragments until the sentinel reply for id arrives, at which
+// point res is sent to exactly once.
+type pendingCmd struct {
+	body bytes.Buffer
+	res  chan cmdResult
+}
+
+type cmdResult struct {
+	body string
+	err  error
+}
+
+// connState is everything tied to one underlying TCP connection. A Client
+// moves on to a new connState whenever auto-reconnect replaces a dead
+// connection; request ids and in-flight commands never cross that
+// boundary.
+type connState struct {
+	conn net.Conn
+
+	writeCh   chan packet
+	doneCh    chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+
+	nextID int32
+
+	mu      sync.Mutex
+	pending map[int32]*pendingCmd
+
+	// next is closed once the Client has decided what happens after this
+	// connState died: either nextState is set to a freshly reconnected
+	// connState, or it is left nil to mean "give up".
+	next      chan struct{}
+	nextState *connState
+}
+
+// Client is a connection to a Factorio/Source RCON server. A Client owns a
+// writer goroutine and a reader goroutine so that CommandContext may be
+// called concurrently from multiple goroutines: each call gets its own
+// request id and its own reply, demultiplexed off the single underlying
+// connection.
 type Client struct {
-	conn     net.Conn
+	target   string
 	password string
+
+	dialTimeout   time.Duration
+	readTimeout   time.Duration
+	keepAlive     time.Duration
+	autoReconnect bool
+
+	mu     sync.Mutex
+	cur    *connState
+	closed bool
+
+	stopKeepAlive chan struct{}
+	closeOnce     sync.Once
 }
 
 // dialOptions configure a Dial call. dialOptions are set by the DialOption
 // values passed to Dial.
 type dialOptions struct {
-	password string
+	password      string
+	dialTimeout   time.Duration
+	readTimeout   time.Duration
+	keepAlive     time.Duration
+	autoReconnect bool
 }
 
 // DialOption configures how we set up the connection.
@@ -97,6 +183,47 @@ func WithPassword(pw string) DialOption {
 	})
 }
 
+// WithDialTimeout bounds how long Dial, and every redial triggered by
+// WithAutoReconnect, may take to establish a TCP connection.
+func WithDialTimeout(d time.Duration) DialOption {
+	return dialOptionFunc(func(o *dialOptions) {
+		o.dialTimeout = d
+	})
+}
+
+// WithReadTimeout makes the Client give up on the connection if no packet
+// at all arrives for d. The deadline is refreshed after every packet
+// received, so it bounds idleness, not the lifetime of a single slow
+// command.
+func WithReadTimeout(d time.Duration) DialOption {
+	return dialOptionFunc(func(o *dialOptions) {
+		o.readTimeout = d
+	})
+}
+
+// WithKeepAlive makes the Client issue a cheap no-op command on an
+// otherwise idle connection every interval, so a silently dropped
+// connection is detected (via WithReadTimeout) instead of only surfacing
+// once the caller happens to run another command.
+func WithKeepAlive(interval time.Duration) DialOption {
+	return dialOptionFunc(func(o *dialOptions) {
+		o.keepAlive = interval
+	})
+}
+
+// WithAutoReconnect makes the Client transparently redial and
+// re-authenticate, with exponential backoff, after the connection is lost.
+// A CommandContext call in flight when the connection drops is retried
+// once on the new connection instead of returning the transport error. That
+// retry is at-least-once: if the command already reached the server before
+// the connection dropped, it may execute twice, so this is only safe for
+// idempotent commands.
+func WithAutoReconnect(enabled bool) DialOption {
+	return dialOptionFunc(func(o *dialOptions) {
+		o.autoReconnect = enabled
+	})
+}
+
 func Dial(target string, opts ...DialOption) (*Client, error) {
 	return DialContext(context.Background(), target, opts...)
 }
@@ -107,40 +234,351 @@ func DialContext(ctx context.Context, target string, opts ...DialOption) (*Clien
 		o.apply(&do)
 	}
 
-	var d net.Dialer // Todo timeouts?
-	conn, err := d.DialContext(ctx, "tcp", target)
+	c := &Client{
+		target:        target,
+		password:      do.password,
+		dialTimeout:   do.dialTimeout,
+		readTimeout:   do.readTimeout,
+		keepAlive:     do.keepAlive,
+		autoReconnect: do.autoReconnect,
+	}
+
+	cs, err := c.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.cur = cs
+
+	if c.keepAlive > 0 {
+		c.stopKeepAlive = make(chan struct{})
+		go c.keepAliveLoop()
+	}
+
+	return c, nil
+}
+
+// connect dials target, authenticates and starts the writer/reader
+// goroutines for a brand new connState.
+func (c *Client) connect(ctx context.Context) (*connState, error) {
+	d := net.Dialer{Timeout: c.dialTimeout}
+	conn, err := d.DialContext(ctx, "tcp", c.target)
 	if err != nil {
 		return nil, err
 	}
 
-	c := Client{
-		conn:     conn,
-		password: do.password,
+	if err := authenticate(conn, c.password, c.dialTimeout); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	cs := &connState{
+		conn:    conn,
+		writeCh: make(chan packet, 8),
+		doneCh:  make(chan struct{}),
+		pending: make(map[int32]*pendingCmd),
+		next:    make(chan struct{}),
 	}
+	go c.writeLoop(cs)
+	go c.readLoop(cs)
+	return cs, nil
+}
 
-	err = c.Authenticate()
+func (c *Client) current() *connState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cur
+}
+
+func (c *Client) writeLoop(cs *connState) {
+	for {
+		select {
+		case p := <-cs.writeCh:
+			if err := sendPacket(cs.conn, p); err != nil {
+				c.fail(cs, err)
+				return
+			}
+		case <-cs.doneCh:
+			return
+		}
+	}
+}
 
-	return &c, err
+func (c *Client) readLoop(cs *connState) {
+	for {
+		p, err := receivePacket(cs.conn, c.readTimeout)
+		if err != nil {
+			c.fail(cs, err)
+			return
+		}
+		c.dispatch(cs, p)
+	}
 }
 
-func (c Client) Authenticate() error {
+// dispatch routes an incoming packet to the pendingCmd that requested it,
+// based on id. A packet whose id carries sentinelBit marks the end of the
+// response for id&^sentinelBit: the accumulated body is delivered and the
+// pendingCmd is removed. Any other packet is treated as one fragment of the
+// response and appended to the pendingCmd's buffer. Packets with unknown
+// ids (e.g. a reply that arrived after CommandContext's caller gave up) are
+// dropped.
+func (c *Client) dispatch(cs *connState, p packet) {
+	id := p.id
+	final := id&sentinelBit != 0
+	if final {
+		id &^= sentinelBit
+	}
+
+	cs.mu.Lock()
+	pc, ok := cs.pending[id]
+	if ok && final {
+		delete(cs.pending, id)
+	}
+	cs.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	if final {
+		pc.res <- cmdResult{body: pc.body.String()}
+		return
+	}
+	pc.body.WriteString(p.body)
+}
+
+// fail tears down cs after a transport error: cs.conn is closed so its fd
+// isn't leaked once cs is replaced; cs.doneCh is closed so every command
+// blocked on cs wakes up and, per commandOn, either surfaces err or retries
+// on the connection that replaces cs; the writer/reader goroutines are
+// stopped; and, if auto-reconnect is enabled and err wasn't from an explicit
+// Close, a reconnect is kicked off in the background. It is safe to call
+// from both loops and more than once; only the first call's conn.Close
+// error is returned.
+func (c *Client) fail(cs *connState, err error) error {
+	var closeErr error
+	cs.closeOnce.Do(func() {
+		cs.closeErr = err
+
+		cs.mu.Lock()
+		cs.pending = make(map[int32]*pendingCmd)
+		cs.mu.Unlock()
+
+		close(cs.doneCh)
+		closeErr = cs.conn.Close()
+
+		if err == ErrClosed || !c.autoReconnect {
+			close(cs.next)
+			return
+		}
+		go c.reconnect(cs)
+	})
+	return closeErr
+}
+
+// reconnect redials and re-authenticates with exponential backoff until it
+// succeeds or the Client is closed, then installs the result as the
+// Client's current connState and wakes up anything waiting on cs.next.
+func (c *Client) reconnect(cs *connState) {
+	backoff := initialReconnectBackoff
+	for {
+		c.mu.Lock()
+		closed := c.closed
+		c.mu.Unlock()
+		if closed {
+			close(cs.next)
+			return
+		}
+
+		ctx := context.Background()
+		var cancel context.CancelFunc
+		if c.dialTimeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, c.dialTimeout)
+		}
+		newCs, err := c.connect(ctx)
+		if cancel != nil {
+			cancel()
+		}
+		if err == nil {
+			c.mu.Lock()
+			if c.closed {
+				c.mu.Unlock()
+				// Close ran after our check above but before we installed
+				// newCs: it never saw this connection, so tear it down
+				// ourselves instead of leaking it.
+				c.fail(newCs, ErrClosed)
+				close(cs.next)
+				return
+			}
+			c.cur = newCs
+			c.mu.Unlock()
+			cs.nextState = newCs
+			close(cs.next)
+			return
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxReconnectBackoff {
+			backoff = maxReconnectBackoff
+		}
+	}
+}
+
+// Command runs cmd and returns the server's response, waiting indefinitely.
+// It is safe to call concurrently from multiple goroutines.
+func (c *Client) Command(cmd string) (string, error) {
+	return c.CommandContext(context.Background(), cmd)
+}
+
+// CommandContext runs cmd and returns the server's response. The response
+// may be reassembled from several RCON packets if it exceeds the 4096 byte
+// single-packet limit. If ctx is done before the response arrives,
+// CommandContext returns ctx.Err(); the reply, if it eventually arrives, is
+// discarded. If the connection drops while cmd is in flight and
+// WithAutoReconnect is enabled, cmd is retried once, transparently, on the
+// reconnected Client instead of returning the transport error. That retry
+// is at-least-once, not exactly-once: if cmd already reached the server
+// before the connection dropped, it may run twice, so non-idempotent
+// commands (bans, kicks, saves, Lua mutations) are not safe to rely on
+// under a flaky connection. It is safe to call concurrently from multiple
+// goroutines.
+func (c *Client) CommandContext(ctx context.Context, cmd string) (string, error) {
+	return c.commandOn(ctx, cmd, c.current(), true)
+}
+
+func (c *Client) commandOn(ctx context.Context, cmd string, cs *connState, retry bool) (string, error) {
+	exec := packet{id: 0, kind: execType, body: cmd}
+	if err := validatePacketSize(&exec); err != nil {
+		return "", err
+	}
+
+	id := atomic.AddInt32(&cs.nextID, 1) & (sentinelBit - 1)
+	pc := &pendingCmd{res: make(chan cmdResult, 1)}
+
+	cs.mu.Lock()
+	cs.pending[id] = pc
+	cs.mu.Unlock()
+
+	exec.id = id
+	term := packet{id: id | sentinelBit, kind: responseType}
+
+	for _, p := range []packet{exec, term} {
+		select {
+		case cs.writeCh <- p:
+		case <-ctx.Done():
+			c.removePending(cs, id)
+			return "", ctx.Err()
+		case <-cs.doneCh:
+			c.removePending(cs, id)
+			return c.afterFail(ctx, cmd, cs, retry)
+		}
+	}
+
+	select {
+	case res := <-pc.res:
+		return res.body, res.err
+	case <-ctx.Done():
+		c.removePending(cs, id)
+		return "", ctx.Err()
+	case <-cs.doneCh:
+		return c.afterFail(ctx, cmd, cs, retry)
+	}
+}
+
+// afterFail decides what to do when a command was in flight on cs when it
+// died: without a retry left, or without anything to reconnect to, the
+// transport error is surfaced; otherwise the command is resent once on the
+// connection that replaced cs.
+func (c *Client) afterFail(ctx context.Context, cmd string, cs *connState, retry bool) (string, error) {
+	if !retry {
+		return "", cs.closeErr
+	}
+	select {
+	case <-cs.next:
+		if cs.nextState == nil {
+			return "", cs.closeErr
+		}
+		return c.commandOn(ctx, cmd, cs.nextState, false)
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (c *Client) removePending(cs *connState, id int32) {
+	cs.mu.Lock()
+	delete(cs.pending, id)
+	cs.mu.Unlock()
+}
+
+// keepAliveLoop issues keepAliveCmd on an idle connection every
+// c.keepAlive, so a dropped connection is noticed via WithReadTimeout
+// instead of only on the caller's next Command. It follows the Client
+// across reconnects.
+func (c *Client) keepAliveLoop() {
+	ticker := time.NewTicker(c.keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ctx := context.Background()
+			var cancel context.CancelFunc
+			if c.readTimeout > 0 {
+				ctx, cancel = context.WithTimeout(ctx, c.readTimeout)
+			}
+			// Errors are expected while a reconnect is in progress; the
+			// next tick will retry against whatever connection is current
+			// by then. Nothing else to do with them here.
+			_, _ = c.commandOn(ctx, keepAliveCmd, c.current(), false)
+			if cancel != nil {
+				cancel()
+			}
+		case <-c.stopKeepAlive:
+			return
+		}
+	}
+}
+
+// Close tears down the underlying connection and stops any background
+// reconnect or keepalive activity. Commands in flight fail with ErrClosed.
+func (c *Client) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		c.closed = true
+		cs := c.cur
+		c.mu.Unlock()
+
+		if c.stopKeepAlive != nil {
+			close(c.stopKeepAlive)
+		}
+
+		err = c.fail(cs, ErrClosed)
+	})
+	return err
+}
+
+// authenticate sends the auth packet and waits for the server's response.
+// If timeout is positive, it bounds each of the (up to two) reads of the
+// response, so a peer that accepts the TCP connection but never answers
+// the auth packet fails authenticate within timeout instead of blocking
+// forever.
+func authenticate(conn net.Conn, password string, timeout time.Duration) error {
 	p := packet{
-		id:   42, // TODO: some kind of id handeling
+		id:   42,
 		kind: authType,
-		body: c.password,
+		body: password,
 	}
-	err := c.send(p)
-	if err != nil {
+	if err := sendPacket(conn, p); err != nil {
 		return err
 	}
 
-	resp, err := c.receive()
+	resp, err := receivePacket(conn, timeout)
 	if err != nil {
 		return err
 	}
 
 	if resp.kind != authResponseType {
-		resp, err = c.receive()
+		resp, err = receivePacket(conn, timeout)
 		if err != nil {
 			return err
 		}
@@ -158,61 +596,64 @@ func (c Client) Authenticate() error {
 	return nil
 }
 
-func (c Client) Command(cmd string) (string, error) {
-	p := packet{
-		id:   42, // TODO: some kind of id handeling
-		kind: execType,
-		body: cmd,
-	}
-	err := c.send(p)
-	if err != nil {
-		return "", err
-	}
-
-	resp, err := c.receive()
+// validatePacketSize reports whether p marshals to within the protocol's
+// 4096-byte packet size limit. It's checked ahead of sendPacket, before p
+// ever reaches a connState's writeCh, so that a too-large command is
+// rejected on its own rather than tearing down the connection (and every
+// other command sharing it) as if the transport had failed.
+func validatePacketSize(p *packet) error {
+	b, err := p.MarshalBinary()
 	if err != nil {
-		return "", err
+		return fmt.Errorf("error marshaling packet: %w", err)
 	}
-	if resp.kind != responseType || resp.id != p.id {
-		return "", fmt.Errorf("protocol error")
+	// The packet size  is a 32-bit little endian integer, representing the length of the request in bytes.
+	// Note that the packet size field itself is not included when determining the size of the packet
+	// The maximum possible value of packet size is 4096
+	if size := len(b); size > 4096 {
+		return fmt.Errorf("message too large: message size %d, maximum size 4096", size)
 	}
-	return resp.body, nil
+	return nil
 }
 
-func (c Client) send(p packet) error {
+func sendPacket(conn net.Conn, p packet) error {
 	b, err := p.MarshalBinary()
 	if err != nil {
 		return fmt.Errorf("error marshaling packet: %w", err)
 	}
-	// The packet size  is a 32-bit little endian integer, representing the length of the request in bytes.
-	// Note that the packet size field itself is not included when determining the size of the packet
-	// The maximum possible value of packet size is 4096
-	size := int32(len(b))
-	if size > 4096 {
+	if size := len(b); size > 4096 {
 		return fmt.Errorf("message too large: message size %d, maximum size 4096", size)
 	}
-	err = binary.Write(c.conn, binary.LittleEndian, size)
+	err = binary.Write(conn, binary.LittleEndian, int32(len(b)))
 	if err != nil {
 		return fmt.Errorf("unable to send message size: %w", err)
 	}
-	_, err = c.conn.Write(b)
+	_, err = conn.Write(b)
 	if err != nil {
 		return fmt.Errorf("error sending packet: %w", err)
 	}
 	return nil
 }
 
-func (c Client) receive() (packet, error) {
+// receivePacket reads the next packet off conn. If timeout is positive, the
+// read must complete within timeout of being called or receivePacket
+// returns a timeout error.
+func receivePacket(conn net.Conn, timeout time.Duration) (packet, error) {
+	if timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+	} else {
+		conn.SetReadDeadline(time.Time{})
+	}
+
 	p := packet{}
 
 	var size int32
-	err := binary.Read(c.conn, binary.LittleEndian, &size)
+	err := binary.Read(conn, binary.LittleEndian, &size)
 	if err != nil {
 		return p, err
 	}
 
 	buf := make([]byte, size)
-	_, err = io.ReadFull(c.conn, buf)
+	_, err = io.ReadFull(conn, buf)
 	if err != nil {
 		return p, err
 	}
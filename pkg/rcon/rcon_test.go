@@ -0,0 +1,325 @@
+package rcon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/glorfischi/factoriod/pkg/rcon/rcontest"
+)
+
+// hungAuthListener accepts TCP connections but never writes anything back,
+// simulating a peer that completes the handshake and then black-holes.
+// acceptCount is incremented for every connection accepted, so callers can
+// observe whether the client is retrying against it rather than wedged on
+// the first attempt.
+func hungAuthListener(t *testing.T) (ln net.Listener, acceptCount *int32) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	acceptCount = new(int32)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(acceptCount, 1)
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+	return ln, acceptCount
+}
+
+func echoHandler(cmd string) string {
+	return "echo:" + cmd
+}
+
+func TestCommandSuccess(t *testing.T) {
+	s := rcontest.NewServer(echoHandler, "secret")
+	defer s.Close()
+
+	c, err := Dial(s.Addr(), WithPassword("secret"))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+
+	got, err := c.Command("hello")
+	if err != nil {
+		t.Fatalf("Command: %v", err)
+	}
+	if want := "echo:hello"; got != want {
+		t.Fatalf("Command body = %q, want %q", got, want)
+	}
+}
+
+func TestDialAuthFailure(t *testing.T) {
+	s := rcontest.NewServer(echoHandler, "secret")
+	defer s.Close()
+
+	_, err := Dial(s.Addr(), WithPassword("wrong"))
+	if err == nil {
+		t.Fatal("Dial with wrong password: want error, got nil")
+	}
+}
+
+func TestCommandFragmentedResponse(t *testing.T) {
+	s := rcontest.NewServer(echoHandler, "secret")
+	defer s.Close()
+	s.SetFragmentSize(4)
+
+	c, err := Dial(s.Addr(), WithPassword("secret"))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+
+	got, err := c.Command("world")
+	if err != nil {
+		t.Fatalf("Command: %v", err)
+	}
+	if want := "echo:world"; got != want {
+		t.Fatalf("Command body = %q, want %q", got, want)
+	}
+}
+
+func TestCommandOverPacketLimit(t *testing.T) {
+	big := strings.Repeat("x", 10000)
+	s := rcontest.NewServer(func(string) string { return big }, "secret")
+	defer s.Close()
+
+	c, err := Dial(s.Addr(), WithPassword("secret"))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+
+	got, err := c.Command("/big")
+	if err != nil {
+		t.Fatalf("Command: %v", err)
+	}
+	if got != big {
+		t.Fatalf("Command body has length %d, want %d", len(got), len(big))
+	}
+}
+
+func TestCommandConcurrent(t *testing.T) {
+	s := rcontest.NewServer(echoHandler, "secret")
+	defer s.Close()
+
+	c, err := Dial(s.Addr(), WithPassword("secret"))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 10; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cmd := fmt.Sprintf("cmd-%d", i)
+			got, err := c.Command(cmd)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if want := "echo:" + cmd; got != want {
+				errs <- fmt.Errorf("Command(%q) = %q, want %q", cmd, got, want)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestCommandContextCancel(t *testing.T) {
+	s := rcontest.NewServer(echoHandler, "secret")
+	defer s.Close()
+	s.SetReplyDelay(time.Second)
+
+	c, err := Dial(s.Addr(), WithPassword("secret"))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = c.CommandContext(ctx, "/never-replies")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("CommandContext err = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestCommandMalformedReply(t *testing.T) {
+	s := rcontest.NewServer(echoHandler, "secret")
+	defer s.Close()
+	s.SendMalformed(1)
+
+	c, err := Dial(s.Addr(), WithPassword("secret"))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+
+	if _, err := c.Command("hello"); err == nil {
+		t.Fatal("Command with malformed reply: want error, got nil")
+	}
+}
+
+func TestCommandWithoutAutoReconnect(t *testing.T) {
+	s := rcontest.NewServer(echoHandler, "secret")
+	defer s.Close()
+
+	c, err := Dial(s.Addr(), WithPassword("secret"))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+
+	s.DropConnections()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := c.Command("ping"); err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Command kept succeeding after the connection was dropped without auto-reconnect")
+		}
+	}
+}
+
+func TestAutoReconnect(t *testing.T) {
+	s := rcontest.NewServer(echoHandler, "secret")
+	defer s.Close()
+
+	c, err := Dial(s.Addr(), WithPassword("secret"), WithAutoReconnect(true), WithDialTimeout(2*time.Second))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+
+	if got, err := c.Command("ping"); err != nil || got != "echo:ping" {
+		t.Fatalf("Command before drop = %q, %v", got, err)
+	}
+
+	s.DropConnections()
+
+	deadline := time.Now().Add(5 * time.Second)
+	var got string
+	for {
+		got, err = c.Command("ping")
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Command after reconnect: %v", err)
+	}
+	if want := "echo:ping"; got != want {
+		t.Fatalf("Command after reconnect = %q, want %q", got, want)
+	}
+}
+
+func TestCommandRetriesAcrossReconnect(t *testing.T) {
+	s := rcontest.NewServer(echoHandler, "secret")
+	defer s.Close()
+	s.SetReplyDelay(200 * time.Millisecond)
+
+	c, err := Dial(s.Addr(), WithPassword("secret"), WithAutoReconnect(true), WithDialTimeout(2*time.Second))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+
+	done := make(chan struct{})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		s.DropConnections()
+	}()
+	go func() {
+		defer close(done)
+		got, err := c.Command("ping")
+		if err != nil {
+			t.Errorf("Command: %v", err)
+			return
+		}
+		if want := "echo:ping"; got != want {
+			t.Errorf("Command = %q, want %q", got, want)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Command in flight during reconnect never returned")
+	}
+}
+
+func TestDialHungAuthTimesOut(t *testing.T) {
+	ln, _ := hungAuthListener(t)
+	defer ln.Close()
+
+	start := time.Now()
+	_, err := Dial(ln.Addr().String(), WithPassword("secret"), WithDialTimeout(200*time.Millisecond))
+	if err == nil {
+		t.Fatal("Dial against a hung-auth peer: want error, got nil")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Dial against a hung-auth peer took %v, want under 2s with a 200ms dial timeout", elapsed)
+	}
+}
+
+// TestReconnectRetriesPastHungAuth exercises reconnect directly against a
+// peer that accepts the TCP handshake but never answers the auth packet.
+// Before authenticate respected a deadline, the first redial attempt would
+// block forever inside connect, wedging the whole backoff loop; it should
+// instead keep retrying, so the listener sees more than one connection.
+func TestReconnectRetriesPastHungAuth(t *testing.T) {
+	hung, acceptCount := hungAuthListener(t)
+	defer hung.Close()
+
+	c := &Client{
+		target:        hung.Addr().String(),
+		dialTimeout:   100 * time.Millisecond,
+		autoReconnect: true,
+	}
+	cs := &connState{next: make(chan struct{})}
+	go c.reconnect(cs)
+
+	deadline := time.Now().Add(3 * time.Second)
+	for atomic.LoadInt32(acceptCount) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := atomic.LoadInt32(acceptCount); got < 2 {
+		t.Fatalf("hung-auth listener accepted %d connections in 3s, want at least 2: reconnect appears wedged on the first hung auth read", got)
+	}
+
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+
+	select {
+	case <-cs.next:
+	case <-time.After(2 * time.Second):
+		t.Fatal("reconnect did not give up once the Client was marked closed")
+	}
+}
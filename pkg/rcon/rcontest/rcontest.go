@@ -0,0 +1,284 @@
+// Package rcontest provides an in-process Source RCON server for testing
+// rcon.Client (or any other RCON implementation) without a running Factorio
+// binary.
+package rcontest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+type packetType int32
+
+const authType packetType = 3
+const authResponseType packetType = 2
+const execType packetType = 2
+const responseType packetType = 0
+
+// maxFragment is the largest response chunk Server will emit in a single
+// packet when auto-fragmenting, mirroring the Source RCON 4096 byte packet
+// limit.
+const maxFragment = 4096
+
+type packet struct {
+	id   int32
+	kind packetType
+	body string
+}
+
+func (p packet) marshal() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, p.id)
+	binary.Write(&buf, binary.LittleEndian, int32(p.kind))
+	buf.WriteString(p.body)
+	buf.Write([]byte{0, 0})
+	return buf.Bytes()
+}
+
+func unmarshal(data []byte) (packet, error) {
+	if len(data) < 10 {
+		return packet{}, fmt.Errorf("rcontest: packet needs at least 10 bytes, got %d", len(data))
+	}
+	return packet{
+		id:   int32(binary.LittleEndian.Uint32(data[0:])),
+		kind: packetType(int32(binary.LittleEndian.Uint32(data[4:]))),
+		body: string(data[8 : len(data)-2]),
+	}, nil
+}
+
+// Server is a fake Source RCON server suitable for testing a client
+// implementation: it accepts connections, performs the real auth handshake
+// against password, and dispatches every exec command it receives to
+// handler, writing handler's return value back as the response.
+//
+// By default the server fragments responses the same way a real Factorio
+// server would, splitting anything over 4096 bytes across several response
+// packets. SetFragmentSize, SetReplyDelay and SendMalformed further let
+// tests exercise multi-packet reassembly, slow servers, and garbled
+// responses.
+type Server struct {
+	ln       net.Listener
+	password string
+	handler  func(cmd string) string
+
+	mu           sync.Mutex
+	fragmentSize int
+	replyDelay   time.Duration
+	malformed    int // number of remaining responses to send malformed
+	conns        []net.Conn
+
+	acceptDone chan struct{}
+}
+
+// NewServer starts a Server listening on 127.0.0.1 and returns it. Every
+// exec command received on an authenticated connection is passed to
+// handler; its return value is sent back as the command's response.
+func NewServer(handler func(cmd string) string, password string) *Server {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("rcontest: listen: %v", err))
+	}
+	s := &Server{ln: ln, password: password, handler: handler, acceptDone: make(chan struct{})}
+	go s.acceptLoop()
+	return s
+}
+
+// Addr returns the address Server is listening on, suitable for
+// rcon.Dial.
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Close stops accepting new connections and closes any connections already
+// accepted. It does not wait for handlers blocked in a SetReplyDelay sleep
+// to return.
+func (s *Server) Close() {
+	s.ln.Close()
+	<-s.acceptDone
+
+	s.mu.Lock()
+	conns := s.conns
+	s.conns = nil
+	s.mu.Unlock()
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+// SetFragmentSize makes the server split every subsequent response into
+// chunks of at most n bytes, each sent as its own response packet. n <= 0
+// restores the default of fragmenting only responses over the Source RCON
+// 4096 byte packet limit.
+func (s *Server) SetFragmentSize(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fragmentSize = n
+}
+
+// SetReplyDelay makes the server wait d before responding to each exec
+// command it receives, to simulate a slow or overloaded server.
+func (s *Server) SetReplyDelay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replyDelay = d
+}
+
+// SendMalformed makes the server reply to the next n exec commands with a
+// packet too short to parse, instead of running them through handler, to
+// exercise a client's handling of a corrupt stream.
+func (s *Server) SendMalformed(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.malformed = n
+}
+
+// DropConnections forcibly closes every connection currently accepted by
+// the server, without closing the listener, so a client exercising
+// reconnect logic has something to reconnect to.
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	conns := s.conns
+	s.conns = nil
+	s.mu.Unlock()
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+func (s *Server) acceptLoop() {
+	defer close(s.acceptDone)
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns = append(s.conns, conn)
+		s.mu.Unlock()
+		go s.serve(conn)
+	}
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		p, err := readPacket(conn)
+		if err != nil {
+			return
+		}
+
+		switch p.kind {
+		case authType:
+			resp := packet{id: p.id, kind: authResponseType}
+			if p.body != s.password {
+				resp.id = -1
+			}
+			if err := writePacket(conn, resp); err != nil {
+				return
+			}
+		case execType:
+			if err := s.reply(conn, p); err != nil {
+				return
+			}
+		case responseType:
+			// Real Source RCON servers respond to an unrecognised request
+			// type by echoing it back with a fixed body. Clients exploit
+			// this to mark the end of a (possibly multi-packet) response:
+			// they send one of these right after an exec packet, under an
+			// id of their own choosing (rcon.Client derives it from the
+			// exec packet's id), so its echo arriving means every fragment
+			// of the exec response has been seen.
+			resp := packet{id: p.id, kind: responseType, body: "Unknown request 0"}
+			if err := writePacket(conn, resp); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) reply(conn net.Conn, req packet) error {
+	s.mu.Lock()
+	delay := s.replyDelay
+	fragSize := s.fragmentSize
+	malformed := s.malformed > 0
+	if malformed {
+		s.malformed--
+	}
+	s.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+
+	if malformed {
+		return writeMalformed(conn)
+	}
+
+	body := s.handler(req.body)
+	for _, chunk := range fragment(body, fragSize) {
+		if err := writePacket(conn, packet{id: req.id, kind: responseType, body: chunk}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fragment splits body into chunks of size n (or maxFragment if n <= 0),
+// always returning at least one chunk so empty responses still produce a
+// packet.
+func fragment(body string, n int) []string {
+	if n <= 0 {
+		n = maxFragment
+	}
+	if len(body) == 0 {
+		return []string{""}
+	}
+	var chunks []string
+	for len(body) > 0 {
+		end := n
+		if end > len(body) {
+			end = len(body)
+		}
+		chunks = append(chunks, body[:end])
+		body = body[end:]
+	}
+	return chunks
+}
+
+func readPacket(r io.Reader) (packet, error) {
+	var size int32
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return packet{}, err
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return packet{}, err
+	}
+	return unmarshal(buf)
+}
+
+func writePacket(w io.Writer, p packet) error {
+	b := p.marshal()
+	if err := binary.Write(w, binary.LittleEndian, int32(len(b))); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// writeMalformed sends a well-framed but too-short packet body, which a
+// correct client must surface as an error rather than hang on.
+func writeMalformed(w io.Writer) error {
+	junk := []byte{0xff, 0xff, 0xff, 0xff}
+	if err := binary.Write(w, binary.LittleEndian, int32(len(junk))); err != nil {
+		return err
+	}
+	_, err := w.Write(junk)
+	return err
+}